@@ -0,0 +1,110 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b2cs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"upspin.io/cloud/storage"
+	"upspin.io/errors"
+)
+
+// TokenSource supplies B2 credentials on demand, letting an operator fetch
+// them from a secret store such as Vault or KMS instead of writing them
+// into the server's plaintext configuration. Register an implementation
+// with RegisterTokenSource and select it with the b2csTokenSource option.
+type TokenSource interface {
+	// Token returns the current account ID and application key.
+	Token(ctx context.Context) (accountID, applicationKey string, err error)
+}
+
+var tokenSources = struct {
+	mu sync.Mutex
+	m  map[string]TokenSource
+}{m: make(map[string]TokenSource)}
+
+// RegisterTokenSource makes ts available as the b2csTokenSource option
+// under name. It is typically called, for side effect, from the init
+// function of a package that the upspinserver binary imports, mirroring
+// storage.Register.
+func RegisterTokenSource(name string, ts TokenSource) {
+	tokenSources.mu.Lock()
+	defer tokenSources.mu.Unlock()
+	tokenSources.m[name] = ts
+}
+
+func lookupTokenSource(name string) (TokenSource, bool) {
+	tokenSources.mu.Lock()
+	defer tokenSources.mu.Unlock()
+	ts, ok := tokenSources.m[name]
+	return ts, ok
+}
+
+// credentialsFile is the JSON shape read from the b2csCredentialsFile
+// option.
+type credentialsFile struct {
+	AccountID      string `json:"accountID"`
+	ApplicationKey string `json:"applicationKey"`
+}
+
+// resolveCredentials determines the account ID/application key pair New
+// should dial B2 with, trying each of the following in order and using the
+// first that is configured:
+//
+//  1. b2csTokenSource, a TokenSource registered with RegisterTokenSource.
+//  2. b2csCredentialsFile, a path to a JSON file holding the credentials.
+//  3. The B2_ACCOUNT_ID/B2_ACCOUNT_KEY environment variables.
+//  4. The literal b2csAccount/b2csAppKey options.
+//
+// Preferring the indirect sources lets an operator keep a master key out
+// of the server's plaintext configuration file when one of them is
+// available.
+func resolveCredentials(ctx context.Context, opts *storage.Opts) (id, key string, err error) {
+	const op errors.Op = "cloud/storage/b2cs.resolveCredentials"
+
+	if name, ok := opts.Opts[tokenSourceOpt]; ok {
+		ts, ok := lookupTokenSource(name)
+		if !ok {
+			return "", "", errors.E(op, errors.Invalid, errors.Errorf("no TokenSource registered under %q", name))
+		}
+		id, key, err := ts.Token(ctx)
+		if err != nil {
+			return "", "", errors.E(op, errors.IO, errors.Errorf("TokenSource %q: %v", name, err))
+		}
+		return id, key, nil
+	}
+
+	if path, ok := opts.Opts[credentialsFileOpt]; ok {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", "", errors.E(op, errors.IO, errors.Errorf("reading %q: %v", credentialsFileOpt, err))
+		}
+		var cf credentialsFile
+		if err := json.Unmarshal(contents, &cf); err != nil {
+			return "", "", errors.E(op, errors.Invalid, errors.Errorf("%s: %v", path, err))
+		}
+		if cf.AccountID == "" || cf.ApplicationKey == "" {
+			return "", "", errors.E(op, errors.Invalid, errors.Errorf("%s: accountID and applicationKey are both required", path))
+		}
+		return cf.AccountID, cf.ApplicationKey, nil
+	}
+
+	if id, key := os.Getenv("B2_ACCOUNT_ID"), os.Getenv("B2_ACCOUNT_KEY"); id != "" && key != "" {
+		return id, key, nil
+	}
+
+	id, idOK := opts.Opts[accountID]
+	key, keyOK := opts.Opts[appKey]
+	if !idOK || !keyOK {
+		return "", "", errors.E(op, errors.Invalid, errors.Errorf(
+			"no B2 credentials configured: set %q, %q, the B2_ACCOUNT_ID/B2_ACCOUNT_KEY environment variables, or %q and %q",
+			tokenSourceOpt, credentialsFileOpt, accountID, appKey))
+	}
+	return id, key, nil
+}
@@ -11,12 +11,16 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"sync"
 
 	b2api "github.com/kurin/blazer/b2"
 
 	"upspin.io/cache"
 	"upspin.io/cloud/storage"
 	"upspin.io/errors"
+	"upspin.io/log"
 	"upspin.io/upspin"
 )
 
@@ -25,37 +29,168 @@ const (
 	accountID  = "b2csAccount"
 	appKey     = "b2csAppKey"
 	bucketName = "b2csBucketName"
+
+	// chunkSize overrides the size, in bytes, of each part of a chunked
+	// upload. If unset, blazer's default is used.
+	chunkSize = "b2csChunkSize"
+	// concurrentUploads overrides the number of chunks blazer will
+	// upload to B2 concurrently for a single object.
+	concurrentUploads = "b2csConcurrentUploads"
+
+	// keyPrefix is prepended to every ref before it is sent to B2. It
+	// lets a single bucket be shared by several Upspin installations
+	// that were each issued an Application Key restricted to their own
+	// prefix.
+	keyPrefix = "b2csKeyPrefix"
+
+	// b2csEncryptionKey names a base64-encoded AES key, or a path to a
+	// file containing one, used to encrypt object bodies before they
+	// leave this process. See loadEncryptionKey.
+	b2csEncryptionKey = "b2csEncryptionKey"
+
+	// plaintextSizeInfoKey is the B2 file-info key used to record the
+	// unencrypted length of a client-side encrypted object, since its
+	// on-disk size is the ciphertext size (salt, per-frame GCM tags and
+	// length prefixes) rather than the size Upspin expects from List.
+	plaintextSizeInfoKey = "b2cs-plaintext-size"
+
+	// encryptedInfoKey is the B2 file-info key used to flag an object as
+	// client-side encrypted by this package, so a Download against a
+	// misconfigured client fails loudly instead of returning ciphertext.
+	encryptedInfoKey = "b2cs-client-encrypted"
+
+	// maxRetriesOpt overrides defaultRetryPolicy.maxRetries.
+	maxRetriesOpt = "b2csMaxRetries"
+	// maxOpsPerSecOpt caps the rate of calls made against B2; 0 or unset
+	// means unlimited.
+	maxOpsPerSecOpt = "b2csMaxOpsPerSec"
+
+	// cacheDirOpt and cacheBytesOpt configure an optional on-disk
+	// read-through/write-through cache in front of Download/Put. Both
+	// must be set together. The cache is bypassed when b2csEncryptionKey
+	// is also set, since it stores plaintext.
+	cacheDirOpt   = "b2csCacheDir"
+	cacheBytesOpt = "b2csCacheBytes"
+
+	// tokenSourceOpt names a TokenSource registered with
+	// RegisterTokenSource to use instead of literal credentials. See
+	// resolveCredentials.
+	tokenSourceOpt = "b2csTokenSource"
+	// credentialsFileOpt is a path to a JSON file holding the account ID
+	// and application key, used instead of literal credentials. See
+	// resolveCredentials.
+	credentialsFileOpt = "b2csCredentialsFile"
 )
 
 // b2csImpl is an implementation of Storage that connects to B2 Cloud Storage
 type b2csImpl struct {
+	// mu guards client and bucket, which RefreshCredentials replaces with
+	// a freshly authenticated pair while other goroutines may be reading
+	// them to make a B2 call.
+	mu     sync.RWMutex
 	client *b2api.Client
 	bucket *b2api.Bucket
+
 	access b2api.BucketType
 
 	cursors *cache.LRU
 
+	// chunkSize and concurrentUploads, when non-zero, are applied to
+	// every *b2api.Writer returned by Object.NewWriter so uploads use the
+	// chunk size and concurrency configured at Dial time. blazer exposes
+	// both as fields on the writer rather than as NewWriter options.
+	chunkSize         int
+	concurrentUploads int
+
+	// prefix is prepended to every ref before it reaches B2, so that an
+	// Application Key restricted to this prefix is sufficient to operate
+	// the store. It is empty when a master key (or an unrestricted
+	// Application Key) is in use.
+	prefix string
+
+	// cipher, when non-nil, client-side encrypts object bodies before
+	// they are uploaded and decrypts them again on download.
+	cipher *cipherSuite
+
+	// retry and limiter govern how every blazer call is retried and
+	// rate-limited; see withRetry.
+	retry   retryPolicy
+	limiter *rateLimiter
+
+	// diskCache, when non-nil, serves Download hits from disk and is
+	// populated by Put and Download misses. It is bypassed entirely when
+	// cipher is set, since it stores plaintext; see cacheEnabled.
+	diskCache *diskCache
+
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
+// cacheEnabled reports whether b2.diskCache should be consulted. The cache
+// stores plaintext, so it is skipped entirely when client-side encryption
+// is on: caching there would write unencrypted object bodies to local
+// disk, defeating the encryption-at-rest the operator opted into.
+func (b2 *b2csImpl) cacheEnabled() bool {
+	return b2.diskCache != nil && b2.cipher == nil
+}
+
+// currentBucket returns the bucket to send the next B2 call through,
+// guarding against a concurrent RefreshCredentials swapping it out.
+func (b2 *b2csImpl) currentBucket() *b2api.Bucket {
+	b2.mu.RLock()
+	defer b2.mu.RUnlock()
+	return b2.bucket
+}
+
+// withPrefix returns ref as it should be sent to B2.
+func (b2 *b2csImpl) withPrefix(ref string) string {
+	return b2.prefix + ref
+}
+
+// trimPrefix returns name, B2's view of an object, as the ref Upspin should
+// see. It is the inverse of withPrefix.
+func (b2 *b2csImpl) trimPrefix(name string) string {
+	return strings.TrimPrefix(name, b2.prefix)
+}
+
+// StreamPutter is implemented by b2csImpl and lets callers that hold an
+// io.Reader of known size upload an object without buffering it in memory
+// first. Callers obtain it with a type assertion on the storage.Storage
+// returned by New.
+type StreamPutter interface {
+	PutReader(ref string, r io.Reader, size int64) error
+}
+
+// RangeDownloader is implemented by b2csImpl and lets callers read a byte
+// range of an object without buffering the whole object in memory first.
+// Callers obtain it with a type assertion on the storage.Storage returned
+// by New.
+type RangeDownloader interface {
+	DownloadRange(ref string, off, length int64) (io.ReadCloser, error)
+}
+
+var (
+	_ StreamPutter    = (*b2csImpl)(nil)
+	_ RangeDownloader = (*b2csImpl)(nil)
+)
+
 func randomToken() string {
 	b := make([]byte, 16)
 	rand.Read(b)
 	return fmt.Sprintf("%x", b)
 }
 
-// New initializes a Storage implementation that stores data to B2 Cloud Storage.
+// New initializes a Storage implementation that stores data to B2 Cloud
+// Storage. Credentials are resolved by resolveCredentials, so they may be
+// supplied via a TokenSource, a credentials file, the B2_ACCOUNT_ID/
+// B2_ACCOUNT_KEY environment variables, or the literal b2csAccount/
+// b2csAppKey options, in that order of preference. The account ID/
+// application key pair may name either the account's master key or a
+// restricted Application Key; in the latter case b2csKeyPrefix should be
+// set to the prefix the key was scoped to, so that it does not need
+// access to the rest of the bucket.
 func New(opts *storage.Opts) (storage.Storage, error) {
 	const op errors.Op = "cloud/storage/b2cs.New"
-	accountIDOpt, ok := opts.Opts[accountID]
-	if !ok {
-		return nil, errors.E(op, errors.Invalid, errors.Errorf("%q option is required", accountID))
-	}
-	appKeyOpt, ok := opts.Opts[appKey]
-	if !ok {
-		return nil, errors.E(op, errors.Invalid, errors.Errorf("%q option is required", appKey))
-	}
 	bucketNameOpt, ok := opts.Opts[bucketName]
 	if !ok {
 		return nil, errors.E(op, errors.Invalid, errors.Errorf("%q option is required", bucketName))
@@ -63,8 +198,15 @@ func New(opts *storage.Opts) (storage.Storage, error) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
+	accountIDOpt, appKeyOpt, err := resolveCredentials(ctx, opts)
+	if err != nil {
+		cancel()
+		return nil, errors.E(op, err)
+	}
+
 	client, err := b2api.NewClient(ctx, accountIDOpt, appKeyOpt)
 	if err != nil {
+		cancel()
 		return nil, errors.E(op, errors.IO, errors.Errorf("unable to create B2 session: %v", err))
 	}
 	bucket, err := client.Bucket(ctx, bucketNameOpt)
@@ -75,12 +217,80 @@ func New(opts *storage.Opts) (storage.Storage, error) {
 		return nil, errors.E(op, errors.IO, errors.Errorf("unable to obtain B2 bucket reference: %v", err))
 	}
 
+	var cSize, cConcurrentUploads int
+	if v, ok := opts.Opts[chunkSize]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.E(op, errors.Invalid, errors.Errorf("%q option must be an integer: %v", chunkSize, err))
+		}
+		cSize = n
+	}
+	if v, ok := opts.Opts[concurrentUploads]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.E(op, errors.Invalid, errors.Errorf("%q option must be an integer: %v", concurrentUploads, err))
+		}
+		cConcurrentUploads = n
+	}
+
+	var cs *cipherSuite
+	if v, ok := opts.Opts[b2csEncryptionKey]; ok {
+		key, err := loadEncryptionKey(v)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		cs, err = newCipherSuite(key)
+		if err != nil {
+			return nil, errors.E(op, errors.Invalid, errors.Errorf("unusable %q: %v", b2csEncryptionKey, err))
+		}
+	}
+
+	retry := defaultRetryPolicy
+	if v, ok := opts.Opts[maxRetriesOpt]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.E(op, errors.Invalid, errors.Errorf("%q option must be an integer: %v", maxRetriesOpt, err))
+		}
+		retry.maxRetries = n
+	}
+	opsPerSec := 0
+	if v, ok := opts.Opts[maxOpsPerSecOpt]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.E(op, errors.Invalid, errors.Errorf("%q option must be an integer: %v", maxOpsPerSecOpt, err))
+		}
+		opsPerSec = n
+	}
+
+	var dc *diskCache
+	if dir, ok := opts.Opts[cacheDirOpt]; ok {
+		bytesOpt, ok := opts.Opts[cacheBytesOpt]
+		if !ok {
+			return nil, errors.E(op, errors.Invalid, errors.Errorf("%q requires %q to also be set", cacheDirOpt, cacheBytesOpt))
+		}
+		maxBytes, err := strconv.ParseInt(bytesOpt, 10, 64)
+		if err != nil {
+			return nil, errors.E(op, errors.Invalid, errors.Errorf("%q option must be an integer: %v", cacheBytesOpt, err))
+		}
+		dc, err = newDiskCache(dir, maxBytes)
+		if err != nil {
+			return nil, errors.E(op, errors.IO, errors.Errorf("unable to set up cache directory %q: %v", dir, err))
+		}
+	}
+
 	return &b2csImpl{
-		client:  client,
-		bucket:  bucket,
-		ctx:     ctx,
-		cancel:  cancel,
-		cursors: cache.NewLRU(100),
+		client:            client,
+		bucket:            bucket,
+		ctx:               ctx,
+		cancel:            cancel,
+		cursors:           cache.NewLRU(100),
+		chunkSize:         cSize,
+		concurrentUploads: cConcurrentUploads,
+		prefix:            opts.Opts[keyPrefix],
+		cipher:            cs,
+		retry:             retry,
+		limiter:           newRateLimiter(opsPerSec),
+		diskCache:         dc,
 	}, nil
 }
 
@@ -88,6 +298,32 @@ func init() {
 	storage.Register("B2CS", New)
 }
 
+// RefreshCredentials re-resolves credentials via resolveCredentials and
+// re-authenticates with B2, without disturbing in-flight state such as the
+// disk cache or list cursors. It lets an operator rotate a
+// b2csTokenSource- or b2csCredentialsFile-backed credential without
+// restarting upspinserver.
+func (b2 *b2csImpl) RefreshCredentials(opts *storage.Opts) error {
+	const op errors.Op = "cloud/storage/b2cs.RefreshCredentials"
+	accountIDOpt, appKeyOpt, err := resolveCredentials(b2.ctx, opts)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	client, err := b2api.NewClient(b2.ctx, accountIDOpt, appKeyOpt)
+	if err != nil {
+		return errors.E(op, errors.IO, errors.Errorf("unable to refresh B2 session: %v", err))
+	}
+	bucket, err := client.Bucket(b2.ctx, b2.currentBucket().Name())
+	if err != nil {
+		return errors.E(op, errors.IO, errors.Errorf("unable to re-obtain B2 bucket reference: %v", err))
+	}
+	b2.mu.Lock()
+	b2.client = client
+	b2.bucket = bucket
+	b2.mu.Unlock()
+	return nil
+}
+
 // Guarantee we implement the Storage interface.
 var _ storage.Storage = (*b2csImpl)(nil)
 
@@ -95,14 +331,18 @@ var _ storage.Storage = (*b2csImpl)(nil)
 func (b2 *b2csImpl) LinkBase() (base string, err error) {
 	const op errors.Op = "cloud/storage/b2cs.LinkBase"
 
-	if b2 == nil || b2.bucket == nil {
+	if b2 == nil {
+		return "", errors.E(op, errors.Transient, errors.Errorf("B2 implementation is not initialized"))
+	}
+	bucket := b2.currentBucket()
+	if bucket == nil {
 		return "", errors.E(op, errors.Transient, errors.Errorf("B2 implementation is not initialized"))
 	}
 	if b2.access == "" {
 		b2.checkAccess()
 	}
 	if b2.access == b2api.Public {
-		return fmt.Sprintf("%s/file/%s/", b2.bucket.BaseURL(), b2.bucket.Name()), nil
+		return fmt.Sprintf("%s/file/%s/%s", bucket.BaseURL(), bucket.Name(), b2.prefix), nil
 	}
 
 	return "", upspin.ErrNotSupported
@@ -111,35 +351,169 @@ func (b2 *b2csImpl) LinkBase() (base string, err error) {
 // Download implements Storage.
 func (b2 *b2csImpl) Download(ref string) ([]byte, error) {
 	const op errors.Op = "cloud/storage/b2cs.Download"
-	buf := &bytes.Buffer{}
-	r := b2.bucket.Object(ref).NewReader(b2.ctx)
-	_, err := io.Copy(buf, r)
-	if b2api.IsNotExist(err) {
+	if b2.cacheEnabled() {
+		if data, ok := b2.diskCache.get(ref); ok {
+			return data, nil
+		}
+	}
+	var buf bytes.Buffer
+	err := withRetry(b2.ctx, b2.retry, b2.limiter, func() error {
+		buf.Reset()
+		r, err := b2.downloadRange(ref, 0, -1)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(&buf, r); err != nil {
+			_ = r.Close()
+			return err
+		}
+		return r.Close()
+	})
+	switch {
+	case b2api.IsNotExist(err):
 		return nil, errors.E(op, errors.NotExist, err)
+	case isRetryable(err):
+		return nil, errors.E(op, errors.Transient, errors.Errorf("unable to download ref %q from B2 bucket %q after retrying: %v", ref, b2.currentBucket().Name(), err))
+	case err != nil:
+		return nil, errors.E(op, errors.IO, errors.Errorf("unable to download ref %q from B2 bucket %q: %v", ref, b2.currentBucket().Name(), err))
 	}
-	if err != nil {
-		return nil, errors.E(op, errors.IO, errors.Errorf("unable to download ref %q from B2 bucket %q: %v", ref, b2.bucket.Name(), err))
+	out := make([]byte, buf.Len())
+	copy(out, buf.Bytes())
+	if b2.cacheEnabled() {
+		if err := b2.diskCache.put(ref, out); err != nil {
+			log.Printf("cloud/storage/b2cs: caching ref %q: %v", ref, err)
+		}
+	}
+	return out, nil
+}
+
+// DownloadRange returns a reader of length bytes of ref starting at offset
+// off, streaming the result directly from B2 instead of buffering the whole
+// object in memory. A negative length reads to the end of the object. The
+// caller must Close the returned io.ReadCloser.
+func (b2 *b2csImpl) DownloadRange(ref string, off, length int64) (io.ReadCloser, error) {
+	const op errors.Op = "cloud/storage/b2cs.DownloadRange"
+	if err := b2.limiter.wait(b2.ctx); err != nil {
+		return nil, errors.E(op, errors.IO, err)
 	}
-	err = r.Close()
+	return b2.downloadRange(ref, off, length)
+}
+
+// downloadRange is DownloadRange without its own rate-limiter wait, for use
+// by Download's withRetry loop, which already calls limiter.wait once per
+// attempt; calling through DownloadRange there would consume two tokens per
+// attempt and throttle reads to half of b2csMaxOpsPerSec.
+func (b2 *b2csImpl) downloadRange(ref string, off, length int64) (io.ReadCloser, error) {
+	const op errors.Op = "cloud/storage/b2cs.DownloadRange"
+	o := b2.currentBucket().Object(b2.withPrefix(ref))
+	var r io.ReadCloser
+	if off == 0 && length < 0 {
+		r = o.NewReader(b2.ctx)
+	} else {
+		if b2.cipher != nil {
+			// Framed ciphertext does not map onto plaintext byte
+			// offsets one-to-one, so ranged reads of encrypted
+			// objects aren't supported yet.
+			return nil, errors.E(op, errors.Invalid, errors.Errorf("ranged reads are not supported on client-side encrypted objects"))
+		}
+		r = o.NewRangeReader(b2.ctx, off, length)
+	}
+	if b2.cipher == nil {
+		return r, nil
+	}
+	dr, err := newDecryptingReader(r, b2.cipher)
 	if err != nil {
-		return nil, errors.E(op, errors.IO, errors.Errorf("unable to finish download of ref %q from B2 bucket %q: %v", ref, b2.bucket.Name(), err))
+		if b2api.IsNotExist(err) {
+			// Mirror the non-encrypted path: let the caller's own
+			// b2api.IsNotExist/isRetryable checks classify this,
+			// instead of burying it in an errors.IO wrap.
+			return nil, err
+		}
+		return nil, errors.E(op, errors.IO, errors.Errorf("unable to start decrypting ref %q: %v", ref, err))
 	}
-	return buf.Bytes(), nil
+	return dr, nil
 }
 
 // Put implements Storage.
 func (b2 *b2csImpl) Put(ref string, contents []byte) error {
-	const op errors.Op = "cloud/storage/b2cs.Put"
-	buf := bytes.NewBuffer(contents)
-	w := b2.bucket.Object(ref).NewWriter(b2.ctx)
-	_, err := io.Copy(w, buf)
-	if err != nil {
-		_ = w.Close()
-		return errors.E(op, errors.IO, errors.Errorf("unable to upload ref %q to B1 bucket %q: %v", ref, b2.bucket.Name(), err))
+	if err := b2.PutReader(ref, bytes.NewReader(contents), int64(len(contents))); err != nil {
+		return err
 	}
-	err = w.Close()
-	if err != nil {
-		return errors.E(op, errors.IO, errors.Errorf("unable to finish upload of ref %q to B1 bucket %q: %v", ref, b2.bucket.Name(), err))
+	if b2.cacheEnabled() {
+		if err := b2.diskCache.put(ref, contents); err != nil {
+			log.Printf("cloud/storage/b2cs: caching ref %q: %v", ref, err)
+		}
+	}
+	return nil
+}
+
+// PutReader uploads size bytes read from r to ref, streaming the data
+// directly into blazer's chunked uploader instead of buffering it in
+// memory first. This is the path large Upspin blocks should use. size must
+// be the exact number of bytes r will yield; PutReader fails if r produces
+// more or fewer.
+func (b2 *b2csImpl) PutReader(ref string, r io.Reader, size int64) error {
+	const op errors.Op = "cloud/storage/b2cs.PutReader"
+	if size < 0 {
+		return errors.E(op, errors.Invalid, errors.Errorf("size must not be negative, got %d", size))
+	}
+	upload := func() error {
+		w := b2.currentBucket().Object(b2.withPrefix(ref)).NewWriter(b2.ctx)
+		if b2.chunkSize > 0 {
+			w.ChunkSize = b2.chunkSize
+		}
+		if b2.concurrentUploads > 0 {
+			w.ConcurrentUploads = b2.concurrentUploads
+		}
+		if b2.cipher != nil {
+			w.Info = map[string]string{
+				encryptedInfoKey:     "aes-gcm-64k",
+				plaintextSizeInfoKey: strconv.FormatInt(size, 10),
+			}
+		}
+		dst := io.WriteCloser(w)
+		if b2.cipher != nil {
+			ew, err := newEncryptingWriter(w, b2.cipher)
+			if err != nil {
+				_ = w.Close()
+				return err
+			}
+			dst = ew
+		}
+		n, err := io.Copy(dst, r)
+		if err != nil {
+			_ = dst.Close()
+			return err
+		}
+		if n != size {
+			_ = dst.Close()
+			return errors.Errorf("read %d bytes from r, want %d", n, size)
+		}
+		return dst.Close()
+	}
+
+	var err error
+	if seeker, ok := r.(io.Seeker); ok {
+		err = withRetry(b2.ctx, b2.retry, b2.limiter, func() error {
+			if _, serr := seeker.Seek(0, io.SeekStart); serr != nil {
+				return serr
+			}
+			return upload()
+		})
+	} else {
+		// r isn't seekable, so a failed upload can't be safely replayed
+		// from the start; make a single attempt, still rate-limited.
+		if werr := b2.limiter.wait(b2.ctx); werr != nil {
+			return errors.E(op, errors.IO, werr)
+		}
+		err = upload()
+	}
+
+	switch {
+	case isRetryable(err):
+		return errors.E(op, errors.Transient, errors.Errorf("unable to upload ref %q to B2 bucket %q after retrying: %v", ref, b2.currentBucket().Name(), err))
+	case err != nil:
+		return errors.E(op, errors.IO, errors.Errorf("unable to upload ref %q to B2 bucket %q: %v", ref, b2.currentBucket().Name(), err))
 	}
 	return nil
 }
@@ -147,13 +521,20 @@ func (b2 *b2csImpl) Put(ref string, contents []byte) error {
 // Delete implements Storage.
 func (b2 *b2csImpl) Delete(ref string) error {
 	const op errors.Op = "cloud/storage/b2cs.Delete"
-	o := b2.bucket.Object(ref)
-	err := o.Delete(b2.ctx)
-	if b2api.IsNotExist(err) {
+	o := b2.currentBucket().Object(b2.withPrefix(ref))
+	err := withRetry(b2.ctx, b2.retry, b2.limiter, func() error {
+		return o.Delete(b2.ctx)
+	})
+	switch {
+	case b2api.IsNotExist(err):
 		return errors.E(op, errors.NotExist, err)
+	case isRetryable(err):
+		return errors.E(op, errors.Transient, errors.Errorf("unable to delete ref %q from B2 bucket %q after retrying: %v", ref, b2.currentBucket().Name(), err))
+	case err != nil:
+		return errors.E(op, errors.IO, errors.Errorf("unable to delete ref %q from B2 bucket %q: %v", ref, b2.currentBucket().Name(), err))
 	}
-	if err != nil {
-		return errors.E(op, errors.IO, errors.Errorf("unable to delete ref %q from B2 bucket %q: %v", ref, b2.bucket.Name(), err))
+	if b2.diskCache != nil {
+		b2.diskCache.evict(ref)
 	}
 	return nil
 }
@@ -165,7 +546,11 @@ var maxResults = 1000
 func (b2 *b2csImpl) getIter(token string) (*b2api.ObjectIterator, error) {
 	const op = "cloud/storage/b2cs.List"
 	if token == "" {
-		return b2.bucket.List(b2.ctx, b2api.ListPageSize(maxResults)), nil
+		opts := []b2api.ListOption{b2api.ListPageSize(maxResults)}
+		if b2.prefix != "" {
+			opts = append(opts, b2api.ListPrefix(b2.prefix))
+		}
+		return b2.currentBucket().List(b2.ctx, opts...), nil
 	}
 	iterator, ok := b2.cursors.Get(token)
 	if !ok {
@@ -188,13 +573,28 @@ func (b2 *b2csImpl) List(token string) (refs []upspin.ListRefsItem, nextToken st
 	for i := 0; i < maxResults && iter.Next(); i++ {
 		obj := iter.Object()
 		attrs, err2 := obj.Attrs(b2.ctx)
+		if isRetryable(err2) {
+			err2 = withRetry(b2.ctx, b2.retry, b2.limiter, func() (err error) {
+				attrs, err = obj.Attrs(b2.ctx)
+				return err
+			})
+		}
 		if err2 != nil {
-			return refs, "", errors.E(op, errors.IO, errors.Errorf("unable to get object attributes %q: %v", obj.Name(), err))
+			if isRetryable(err2) {
+				return refs, "", errors.E(op, errors.Transient, errors.Errorf("unable to get object attributes %q after retrying: %v", obj.Name(), err2))
+			}
+			return refs, "", errors.E(op, errors.IO, errors.Errorf("unable to get object attributes %q: %v", obj.Name(), err2))
 		}
 
+		size := attrs.Size
+		if s, ok := attrs.Info[plaintextSizeInfoKey]; ok {
+			if n, perr := strconv.ParseInt(s, 10, 64); perr == nil {
+				size = n
+			}
+		}
 		refs = append(refs, upspin.ListRefsItem{
-			Ref:  upspin.Reference(obj.Name()),
-			Size: attrs.Size,
+			Ref:  upspin.Reference(b2.trimPrefix(obj.Name())),
+			Size: size,
 		})
 	}
 
@@ -211,14 +611,71 @@ func (b2 *b2csImpl) List(token string) (refs []upspin.ListRefsItem, nextToken st
 // Close implements Storage.
 func (b2 *b2csImpl) Close() {
 	b2.cancel()
+	b2.mu.Lock()
 	b2.bucket = nil
 	b2.client = nil
+	b2.mu.Unlock()
+}
+
+// SetLifecycle installs a single bucket-wide lifecycle rule covering every
+// ref under b2's prefix: a version is auto-hidden daysUploaded days after
+// it was uploaded (0 means as soon as a newer version exists), and a
+// hidden version is hard-deleted daysHidden days after that. Without such
+// a rule, refs deleted through Delete only ever get their latest version
+// hidden and accumulate storage cost forever.
+func (b2 *b2csImpl) SetLifecycle(daysHidden, daysUploaded int) error {
+	const op errors.Op = "cloud/storage/b2cs.SetLifecycle"
+	rule := b2api.LifecycleRule{
+		Prefix:                 b2.prefix,
+		DaysNewUntilHidden:     daysUploaded,
+		DaysHiddenUntilDeleted: daysHidden,
+	}
+	bucket := b2.currentBucket()
+	err := withRetry(b2.ctx, b2.retry, b2.limiter, func() error {
+		_, err := bucket.Update(b2.ctx, &b2api.BucketAttrs{LifecycleRules: []b2api.LifecycleRule{rule}})
+		return err
+	})
+	if err != nil {
+		return errors.E(op, errors.IO, errors.Errorf("unable to set lifecycle rule on B2 bucket %q: %v", bucket.Name(), err))
+	}
+	return nil
+}
+
+// PurgeVersions hard-deletes every version of ref, including hidden ones,
+// for GDPR-style erasure. Ordinary Delete only hides the latest version;
+// older and hidden versions otherwise linger until a lifecycle rule (see
+// SetLifecycle) removes them.
+func (b2 *b2csImpl) PurgeVersions(ref string) error {
+	const op errors.Op = "cloud/storage/b2cs.PurgeVersions"
+	bucket := b2.currentBucket()
+	name := b2.withPrefix(ref)
+	iter := bucket.List(b2.ctx, b2api.ListHidden(), b2api.ListPrefix(name))
+	for iter.Next() {
+		obj := iter.Object()
+		if obj.Name() != name {
+			continue
+		}
+		err := withRetry(b2.ctx, b2.retry, b2.limiter, func() error {
+			return obj.Delete(b2.ctx)
+		})
+		if err != nil && !b2api.IsNotExist(err) {
+			return errors.E(op, errors.IO, errors.Errorf("unable to purge a version of ref %q from B2 bucket %q: %v", ref, bucket.Name(), err))
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return errors.E(op, errors.IO, errors.Errorf("unable to list versions of ref %q in B2 bucket %q: %v", ref, bucket.Name(), err))
+	}
+	if b2.diskCache != nil {
+		b2.diskCache.evict(ref)
+	}
+	return nil
 }
 
 func (b2 *b2csImpl) deleteBucket() error {
+	bucket := b2.currentBucket()
 	// Remove all content from the bucket first,
 	// otherwise the deletion will fail.
-	iter := b2.bucket.List(b2.ctx, b2api.ListHidden(), b2api.ListPageSize(128))
+	iter := bucket.List(b2.ctx, b2api.ListHidden(), b2api.ListPageSize(128))
 	for iter.Next() {
 		if err := iter.Object().Delete(b2.ctx); err != nil {
 			return err
@@ -227,16 +684,20 @@ func (b2 *b2csImpl) deleteBucket() error {
 	if err := iter.Err(); err != nil {
 		return err
 	}
-	return b2.bucket.Delete(b2.ctx)
+	return bucket.Delete(b2.ctx)
 }
 
 // checkAccess retrieves b2.attrs as the attributes from b2.bucket or sets a useful fallback value.
 func (b2 *b2csImpl) checkAccess() {
-	if b2 == nil || b2.bucket == nil {
+	if b2 == nil {
+		return
+	}
+	bucket := b2.currentBucket()
+	if bucket == nil {
 		return
 	}
 	b2.access = b2api.Private
-	attrs, err := b2.bucket.Attrs(b2.ctx)
+	attrs, err := bucket.Attrs(b2.ctx)
 	if err != nil {
 		// Use the fallback, that's all the error handling we need.
 		return
@@ -31,7 +31,35 @@ var (
 	objectContents = []byte(fmt.Sprintf("This is test at %v", time.Now()))
 )
 
+func TestKeyPrefix(t *testing.T) {
+	b2 := &b2csImpl{prefix: "myapp/"}
+	const ref = "some/ref"
+	prefixed := b2.withPrefix(ref)
+	if want := "myapp/some/ref"; prefixed != want {
+		t.Errorf("withPrefix(%q) = %q, want %q", ref, prefixed, want)
+	}
+	if got := b2.trimPrefix(prefixed); got != ref {
+		t.Errorf("trimPrefix(%q) = %q, want %q", prefixed, got, ref)
+	}
+}
+
+// requireLiveB2 skips t unless -use_b2cs was passed, for the tests in this
+// file that exercise live B2 Cloud Storage rather than pure logic. It must
+// be called by every such test: TestMain runs the whole package's tests
+// regardless of -use_b2cs, so that the unit tests elsewhere in this
+// package (TestKeyPrefix here, and the other packages' tests) get to run
+// under a plain "go test ./...".
+func requireLiveB2(t *testing.T) {
+	if !*useB2CS {
+		t.Skip(`cloud/storage/b2cs: skipping test as it requires B2 Cloud Storage access. To
+enable this test, provide an account and key with flags -account and -appkey,
+respectively, to upload to an B2 Cloud Storage bucket named by flag -test_bucket
+and then set this test's flag -use_b2cs.`)
+	}
+}
+
 func TestListingEmptyContainer(t *testing.T) {
+	requireLiveB2(t)
 	l := client.(*b2csImpl)
 	refs, nextToken, err := l.List("")
 	if err != nil {
@@ -46,6 +74,7 @@ func TestListingEmptyContainer(t *testing.T) {
 }
 
 func TestListingWithPagination(t *testing.T) {
+	requireLiveB2(t)
 	putRefs := make([]string, 10)
 	for i := 0; i < 10; i++ {
 		ref := fmt.Sprintf("ref%d", i)
@@ -95,6 +124,7 @@ func getAllRefs(perPage int, maxCalls int) (allRefs []upspin.ListRefsItem, callC
 // supplied with command-line flags to "go test". The test bucket is deleted
 // when the tests ran.
 func TestPutGetAndDownload(t *testing.T) {
+	requireLiveB2(t)
 	err := client.Put(fileName, testData)
 	if err != nil {
 		t.Fatalf("Can't put: %v", err)
@@ -117,6 +147,7 @@ func TestPutGetAndDownload(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
+	requireLiveB2(t)
 	// Use a dedicated fileName for the deletion test, otherwise
 	// it will simply be second version of the same file as for
 	// TestPutGetAndDownload. Delete would then only erase one version and
@@ -137,30 +168,53 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestPurgeVersions(t *testing.T) {
+	requireLiveB2(t)
+	l := client.(*b2csImpl)
+	fileNamePurge := "purgetest-" + fileName
+	// Upload two versions so PurgeVersions has more than a single hidden
+	// marker to remove.
+	if err := client.Put(fileNamePurge, testData); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Put(fileNamePurge, testData); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.PurgeVersions(fileNamePurge); err != nil {
+		t.Fatalf("PurgeVersions: %v", err)
+	}
+	refs, _, err := getAllRefs(maxResults, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range refs {
+		if string(r.Ref) == fileNamePurge {
+			t.Errorf("ref %q still listed after PurgeVersions", fileNamePurge)
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
-	if !*useB2CS {
-		log.Printf(`
-cloud/storage/b2cs: skipping test as it requires B2 Cloud Storage access. To
-enable this test, provide an account and key with flags -account and -appkey,
-respectively, to upload to an B2 Cloud Storage bucket named by flag -test_bucket
-and then set this test's flag -use_b2cs.
-`)
-		os.Exit(0)
-	}
-	// Create client that writes to test bucket.
-	var err error
-	client, err = storage.Dial("B2CS",
-		storage.WithKeyValue("b2csBucketName", *testBucket),
-		storage.WithKeyValue("b2csAccount", *testAccountID),
-		storage.WithKeyValue("b2csAppKey", *testAppKey))
-	if err != nil {
-		log.Fatalf("cloud/storage/b2cs: couldn't set up client: %v", err)
+	// Dial a live B2 client only when asked to: the package's unit tests
+	// don't need one and must still run under a plain "go test ./...".
+	// The live-B2 tests each call requireLiveB2 to skip themselves when
+	// -use_b2cs is unset.
+	if *useB2CS {
+		var err error
+		client, err = storage.Dial("B2CS",
+			storage.WithKeyValue("b2csBucketName", *testBucket),
+			storage.WithKeyValue("b2csAccount", *testAccountID),
+			storage.WithKeyValue("b2csAppKey", *testAppKey))
+		if err != nil {
+			log.Fatalf("cloud/storage/b2cs: couldn't set up client: %v", err)
+		}
 	}
 	code := m.Run()
-	// Clean up.
-	if err := client.(*b2csImpl).deleteBucket(); err != nil {
-		log.Printf("cloud/storage/b2cs: deleteBucket failed: %v", err)
+	if client != nil {
+		if err := client.(*b2csImpl).deleteBucket(); err != nil {
+			log.Printf("cloud/storage/b2cs: deleteBucket failed: %v", err)
+		}
 	}
 	os.Exit(code)
 }
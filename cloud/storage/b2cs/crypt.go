@@ -0,0 +1,244 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b2cs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"upspin.io/errors"
+)
+
+// frameSize is the size, in plaintext bytes, of each chunk sealed by the
+// client-side cipherSuite. Framing the object lets a decryptReader be used
+// as a random-access-friendly stream instead of requiring the whole object
+// to be sealed, and decrypted, as one unit.
+const frameSize = 64 * 1024
+
+// nonceSize and saltSize together make up the AEAD nonce for each frame: a
+// 12-byte (96-bit) value chosen once per object (saltSize) concatenated
+// with a 4-byte big-endian frame counter. saltSize is large enough that,
+// across every object ever encrypted under one key, the odds of two
+// objects sharing a salt (and so colliding on frame-0's nonce) stay
+// negligible; an 8-byte salt would collide by the birthday bound after
+// around 2^32 objects, which is a real limit for a long-lived bucket.
+const (
+	nonceSize = 16
+	saltSize  = 12
+)
+
+// maxFrame is the largest frame counter that fits in nonceSize-saltSize
+// bytes. An object exceeding it would wrap the counter and reuse a nonce.
+const maxFrame = 1<<(8*(nonceSize-saltSize)) - 1
+
+// cipherSuite seals and opens the frameSize chunks an encryptingWriter and
+// decryptingReader exchange with B2.
+type cipherSuite struct {
+	aead cipher.AEAD
+}
+
+// newCipherSuite builds a cipherSuite from a raw AES key (16, 24 or 32
+// bytes).
+func newCipherSuite(key []byte) (*cipherSuite, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, nonceSize)
+	if err != nil {
+		return nil, err
+	}
+	return &cipherSuite{aead: aead}, nil
+}
+
+func (cs *cipherSuite) nonce(salt []byte, frame uint64) []byte {
+	n := make([]byte, nonceSize)
+	copy(n, salt)
+	binary.BigEndian.PutUint32(n[saltSize:], uint32(frame))
+	return n
+}
+
+// loadEncryptionKey resolves the b2csEncryptionKey option into a raw AES
+// key. The option value is tried, in order, as a base64-encoded key and as
+// the path to a file containing one.
+func loadEncryptionKey(opt string) ([]byte, error) {
+	const op errors.Op = "cloud/storage/b2cs.loadEncryptionKey"
+	if key, err := base64.StdEncoding.DecodeString(opt); err == nil {
+		if err := checkKeySize(key); err != nil {
+			return nil, errors.E(op, errors.Invalid, err)
+		}
+		return key, nil
+	}
+	contents, err := ioutil.ReadFile(opt)
+	if err != nil {
+		return nil, errors.E(op, errors.Invalid, errors.Errorf("%q is neither a base64 key nor a readable key file: %v", b2csEncryptionKey, err))
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytesTrimSpace(contents)))
+	if err != nil {
+		return nil, errors.E(op, errors.Invalid, errors.Errorf("%s: key file does not contain a base64 key: %v", opt, err))
+	}
+	if err := checkKeySize(key); err != nil {
+		return nil, errors.E(op, errors.Invalid, err)
+	}
+	return key, nil
+}
+
+func checkKeySize(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return errors.Errorf("%q must decode to a 16, 24 or 32 byte AES key, got %d bytes", b2csEncryptionKey, len(key))
+	}
+}
+
+// bytesTrimSpace trims leading and trailing ASCII whitespace, which is all
+// a key file needs: it avoids importing "strings" purely for this.
+func bytesTrimSpace(b []byte) []byte {
+	start := 0
+	for start < len(b) && isSpace(b[start]) {
+		start++
+	}
+	end := len(b)
+	for end > start && isSpace(b[end-1]) {
+		end--
+	}
+	return b[start:end]
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// encryptingWriter wraps an io.WriteCloser, sealing the plaintext written
+// to it in frameSize chunks and writing a random per-object salt ahead of
+// the first frame.
+type encryptingWriter struct {
+	w     io.WriteCloser
+	cs    *cipherSuite
+	salt  []byte
+	frame uint64
+	buf   []byte
+}
+
+func newEncryptingWriter(w io.WriteCloser, cs *cipherSuite) (*encryptingWriter, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{w: w, cs: cs, salt: salt}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		room := frameSize - len(e.buf)
+		if room > len(p) {
+			room = len(p)
+		}
+		e.buf = append(e.buf, p[:room]...)
+		p = p[room:]
+		if len(e.buf) == frameSize {
+			if err := e.sealFrame(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *encryptingWriter) sealFrame() error {
+	if e.frame > maxFrame {
+		return errors.Errorf("object exceeds %d frames, cannot seal further without reusing a nonce", maxFrame)
+	}
+	sealed := e.cs.aead.Seal(nil, e.cs.nonce(e.salt, e.frame), e.buf, nil)
+	e.frame++
+	e.buf = e.buf[:0]
+	var lenPrefix [4]byte
+	binary.BigEndian.PutUint32(lenPrefix[:], uint32(len(sealed)))
+	if _, err := e.w.Write(lenPrefix[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Close seals any buffered, less-than-frameSize plaintext as a final frame
+// and closes the underlying writer.
+func (e *encryptingWriter) Close() error {
+	if len(e.buf) > 0 {
+		if err := e.sealFrame(); err != nil {
+			_ = e.w.Close()
+			return err
+		}
+	}
+	return e.w.Close()
+}
+
+// decryptingReader wraps an io.ReadCloser of frames produced by
+// encryptingWriter, presenting the original plaintext.
+type decryptingReader struct {
+	r     io.ReadCloser
+	cs    *cipherSuite
+	salt  []byte
+	frame uint64
+	buf   []byte
+	err   error
+}
+
+func newDecryptingReader(r io.ReadCloser, cs *cipherSuite) (*decryptingReader, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, err
+	}
+	return &decryptingReader{r: r, cs: cs, salt: salt}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	if len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if err := d.readFrame(); err != nil {
+			d.err = err
+			if len(d.buf) == 0 {
+				return 0, err
+			}
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptingReader) readFrame() error {
+	var lenPrefix [4]byte
+	if _, err := io.ReadFull(d.r, lenPrefix[:]); err != nil {
+		return err
+	}
+	sealed := make([]byte, binary.BigEndian.Uint32(lenPrefix[:]))
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return err
+	}
+	plain, err := d.cs.aead.Open(nil, d.cs.nonce(d.salt, d.frame), sealed, nil)
+	if err != nil {
+		return err
+	}
+	d.frame++
+	d.buf = plain
+	return nil
+}
+
+func (d *decryptingReader) Close() error {
+	return d.r.Close()
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b2cs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// diskCache is a bounded, on-disk, read-through/write-through cache of
+// object bodies keyed by ref, used to avoid round-tripping to B2 (and
+// being billed a class-B transaction) for repeated reads of the same hot
+// Upspin block. It evicts least-recently-used entries once the total size
+// of cached bodies exceeds maxBytes.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // ref -> element of order
+	order   *list.List               // front = most recently used
+	used    int64
+}
+
+type diskCacheEntry struct {
+	ref  string
+	path string
+	size int64
+}
+
+// newDiskCache opens (creating if necessary) an on-disk cache rooted at
+// dir, bounded to maxBytes of cached object bodies.
+func newDiskCache(dir string, maxBytes int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &diskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}, nil
+}
+
+// pathFor returns the path at which ref's body would be cached. The ref is
+// hashed so that refs containing path separators or other characters
+// unsuitable for file names are handled uniformly.
+func (c *diskCache) pathFor(ref string) string {
+	sum := sha256.Sum256([]byte(ref))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// get returns ref's cached body, if any.
+func (c *diskCache) get(ref string) (contents []byte, ok bool) {
+	c.mu.Lock()
+	el, found := c.entries[ref]
+	if found {
+		c.order.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !found {
+		return nil, false
+	}
+	entry := el.Value.(*diskCacheEntry)
+	data, err := ioutil.ReadFile(entry.path)
+	if err != nil {
+		// The cached file is gone from under us; treat it as a miss
+		// and drop the now-dangling entry.
+		c.evict(ref)
+		return nil, false
+	}
+	return data, true
+}
+
+// put caches contents as ref's body, evicting older entries as needed to
+// stay within maxBytes.
+func (c *diskCache) put(ref string, contents []byte) error {
+	path := c.pathFor(ref)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, contents, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[ref]; ok {
+		entry := el.Value.(*diskCacheEntry)
+		c.used += int64(len(contents)) - entry.size
+		entry.size = int64(len(contents))
+		c.order.MoveToFront(el)
+	} else {
+		entry := &diskCacheEntry{ref: ref, path: path, size: int64(len(contents))}
+		c.entries[ref] = c.order.PushFront(entry)
+		c.used += entry.size
+	}
+	c.evictLocked()
+	return nil
+}
+
+// evict removes ref from the cache, if present.
+func (c *diskCache) evict(ref string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[ref]
+	if !ok {
+		return
+	}
+	c.removeLocked(el)
+}
+
+func (c *diskCache) removeLocked(el *list.Element) {
+	entry := el.Value.(*diskCacheEntry)
+	c.order.Remove(el)
+	delete(c.entries, entry.ref)
+	c.used -= entry.size
+	os.Remove(entry.path)
+}
+
+func (c *diskCache) evictLocked() {
+	for c.used > c.maxBytes {
+		back := c.order.Back()
+		if back == nil {
+			return
+		}
+		c.removeLocked(back)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b2cs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDiskCacheHitAndEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "b2cs-diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := newDiskCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.put("a", []byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := c.get("a"); !ok || string(data) != "12345" {
+		t.Fatalf("get(a) = %q, %v, want %q, true", data, ok, "12345")
+	}
+
+	// Adding a second 5-byte entry fits within the 10-byte budget.
+	if err := c.put("b", []byte("67890")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("a was evicted before the cache was full")
+	}
+
+	// A third entry pushes the cache over budget; the least recently
+	// used entry, b, should be evicted to make room.
+	if err := c.put("c", []byte("abcde")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get("b"); ok {
+		t.Error("b should have been evicted to stay within maxBytes")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("a should still be cached; it was used more recently than b")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("c should be cached; it was just added")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 The Upspin Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package b2cs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+type temporaryErr struct{ temp bool }
+
+func (e temporaryErr) Error() string   { return "temporary error" }
+func (e temporaryErr) Temporary() bool { return e.temp }
+
+// timeoutErr mimics the net package errors (e.g. from a dialer or HTTP
+// round tripper timing out) that a *url.Error wraps; blazer's HTTP calls
+// go through net/http, so a *url.Error is a representative shape for the
+// errors blazer's own retry-worthy failures would actually take.
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func TestIsRetryableOnWrappedNetworkError(t *testing.T) {
+	// *url.Error forwards Temporary() to the error it wraps, and is what
+	// net/http surfaces for a request that failed with a timeout or
+	// connection reset -- the conditions this package means to retry.
+	urlErr := &url.Error{Op: "Get", URL: "https://api.backblazeb2.com/b2api/v2/b2_download_file_by_name", Err: timeoutErr{}}
+	if !isRetryable(urlErr) {
+		t.Errorf("isRetryable(%v) = false, want true", urlErr)
+	}
+
+	// Further wrapped with fmt.Errorf's %w, as blazer or this package's
+	// own error paths might do, it must still be recognized.
+	wrapped := fmt.Errorf("b2_download_file_by_name: %w", urlErr)
+	if !isRetryable(wrapped) {
+		t.Errorf("isRetryable(%v) = false, want true", wrapped)
+	}
+
+	// A *url.Error wrapping a permanent failure (e.g. a 401) must not be
+	// retried just because it's the same outer type.
+	permanent := &url.Error{Op: "Get", URL: "https://api.backblazeb2.com/b2api/v2/b2_download_file_by_name", Err: errors.New("unauthorized")}
+	if isRetryable(permanent) {
+		t.Errorf("isRetryable(%v) = true, want false", permanent)
+	}
+}
+
+func TestWithRetryGivesUpOnNonRetryableError(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), retryPolicy{maxRetries: 5}, newRateLimiter(0), func() error {
+		calls++
+		return errors.New("permanent failure")
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls != 1 {
+		t.Errorf("fn was called %d times, want 1", calls)
+	}
+}
+
+func TestWithRetryRetriesTemporaryErrors(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), retryPolicy{maxRetries: 3, baseDelay: 0, maxDelay: 0}, newRateLimiter(0), func() error {
+		calls++
+		if calls < 3 {
+			return temporaryErr{temp: true}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("fn was called %d times, want 3", calls)
+	}
+}
+
+func TestWithRetryExhaustsRetries(t *testing.T) {
+	calls := 0
+	err := withRetry(context.Background(), retryPolicy{maxRetries: 2, baseDelay: 0, maxDelay: 0}, newRateLimiter(0), func() error {
+		calls++
+		return temporaryErr{temp: true}
+	})
+	if !isRetryable(err) {
+		t.Fatalf("expected a retryable error to be returned, got %v", err)
+	}
+	if want := 3; calls != want { // one initial attempt plus maxRetries retries
+		t.Errorf("fn was called %d times, want %d", calls, want)
+	}
+}
@@ -9,8 +9,10 @@ package main // import "b2.upspin.io/cmd/upspin-setupstorage-b2cs"
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -36,6 +38,15 @@ the server configuration files in $where/$domain/ to use the specified bucket.
 
 Before running this command, you should ensure you have an B2 account.
 
+-account and -appkey may name either your account's master key or an
+Application Key restricted to a single bucket and name prefix; when using a
+restricted key, pass its prefix with -key-prefix so the server only ever
+addresses objects the key is allowed to see.
+
+Instead of -account and -appkey, -credentials-file may name a JSON file
+holding {"accountID": "...", "applicationKey": "..."}; the server config
+will then reference the file rather than embedding the key in plain text.
+
 If something goes wrong during the setup process, you can run the same command
 with the -clean flag. It will attempt to remove any entities previously created
 with the same options provided.
@@ -53,11 +64,13 @@ func main() {
 	var err error
 
 	var (
-		where       = flag.String("where", filepath.Join(os.Getenv("HOME"), "upspin", "deploy"), "`directory` to store private configuration files")
-		domain      = flag.String("domain", "", "domain `name` for this Upspin installation")
-		clean       = flag.Bool("clean", false, "deletes all artifacts that would be created using this command")
-		b2AccountID = flag.String("account", "", "B2 Cloud Storage account ID")
-		b2AppKey    = flag.String("appkey", "", "B2 Cloud Storage application key")
+		where           = flag.String("where", filepath.Join(os.Getenv("HOME"), "upspin", "deploy"), "`directory` to store private configuration files")
+		domain          = flag.String("domain", "", "domain `name` for this Upspin installation")
+		clean           = flag.Bool("clean", false, "deletes all artifacts that would be created using this command")
+		b2AccountID     = flag.String("account", "", "B2 Cloud Storage account ID")
+		b2AppKey        = flag.String("appkey", "", "B2 Cloud Storage application key")
+		keyPrefix       = flag.String("key-prefix", "", "`prefix` the -account/-appkey Application Key is restricted to, if any")
+		credentialsFile = flag.String("credentials-file", "", "`path` to a JSON file holding the account ID and application key, as an alternative to -account/-appkey")
 	)
 
 	s.ParseFlags(flag.CommandLine, os.Args[1:], help,
@@ -68,11 +81,18 @@ func main() {
 	if len(*domain) == 0 {
 		s.Exitf("the -domain flag must be provided")
 	}
+	if *credentialsFile != "" {
+		id, key, err := readCredentialsFile(*credentialsFile)
+		if err != nil {
+			s.Exitf("%v", err)
+		}
+		*b2AccountID, *b2AppKey = id, key
+	}
 	if len(*b2AccountID) == 0 {
-		s.Exitf("the -account flag must be provided")
+		s.Exitf("the -account flag must be provided, unless -credentials-file is")
 	}
 	if len(*b2AppKey) == 0 {
-		s.Exitf("the -appkey flag must be provided")
+		s.Exitf("the -appkey flag must be provided, unless -credentials-file is")
 	}
 
 	s.client, err = b2api.NewClient(context.Background(), *b2AccountID, *b2AppKey)
@@ -96,8 +116,17 @@ func main() {
 	cfg.StoreConfig = []string{
 		"backend=B2CS",
 		"b2csBucketName=" + bucketName,
-		"b2csAccount=" + *b2AccountID,
-		"b2csAppKey=" + *b2AppKey,
+	}
+	if *credentialsFile != "" {
+		cfg.StoreConfig = append(cfg.StoreConfig, "b2csCredentialsFile="+*credentialsFile)
+	} else {
+		cfg.StoreConfig = append(cfg.StoreConfig,
+			"b2csAccount="+*b2AccountID,
+			"b2csAppKey="+*b2AppKey,
+		)
+	}
+	if *keyPrefix != "" {
+		cfg.StoreConfig = append(cfg.StoreConfig, "b2csKeyPrefix="+*keyPrefix)
 	}
 	s.WriteServerConfig(cfgPath, cfg)
 
@@ -105,8 +134,39 @@ func main() {
 	s.ExitNow()
 }
 
+// readCredentialsFile reads the JSON-encoded {"accountID", "applicationKey"}
+// credentials at path, in the same shape the b2cs storage backend reads at
+// runtime via b2csCredentialsFile.
+func readCredentialsFile(path string) (accountID, appKey string, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("reading -credentials-file: %v", err)
+	}
+	var cf struct {
+		AccountID      string `json:"accountID"`
+		ApplicationKey string `json:"applicationKey"`
+	}
+	if err := json.Unmarshal(contents, &cf); err != nil {
+		return "", "", fmt.Errorf("parsing -credentials-file: %v", err)
+	}
+	if cf.AccountID == "" || cf.ApplicationKey == "" {
+		return "", "", fmt.Errorf("-credentials-file %q: accountID and applicationKey are both required", path)
+	}
+	return cf.AccountID, cf.ApplicationKey, nil
+}
+
+// defaultLifecycleRule deletes a hidden file version one day after it was
+// hidden and keeps only the most recent version of each file, so that refs
+// deleted by the upspinserver don't accumulate storage cost forever.
+var defaultLifecycleRule = b2api.LifecycleRule{
+	DaysNewUntilHidden:     1,
+	DaysHiddenUntilDeleted: 1,
+}
+
 func (s *state) createBucket(bucketName string) error {
-	_, err := s.client.NewBucket(context.Background(), bucketName, nil)
+	_, err := s.client.NewBucket(context.Background(), bucketName, &b2api.BucketAttrs{
+		LifecycleRules: []b2api.LifecycleRule{defaultLifecycleRule},
+	})
 	return err
 }
 